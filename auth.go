@@ -0,0 +1,238 @@
+package main
+
+import (
+	"crypto/rand"
+	"encoding/base64"
+	"errors"
+	"log"
+	"net/http"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/dgrijalva/jwt-go"
+	"github.com/julienschmidt/httprouter"
+)
+
+// jwtSecret signs and verifies session tokens. It must be set via the
+// JWT_SECRET environment variable before the server starts.
+var jwtSecret []byte
+
+func init() {
+	secret := os.Getenv("JWT_SECRET")
+	if secret == "" {
+		log.Fatal("JWT_SECRET must be set")
+	}
+
+	jwtSecret = []byte(secret)
+}
+
+// sessionCookieName returns the cookie used to hold the session for a
+// given provider. Cookies are scoped per provider (rather than one shared
+// "session" cookie) so a user can be logged in to several backends at
+// once and target one via ?provider= or a :provider URL segment.
+func sessionCookieName(provider string) string {
+	return "session_" + provider
+}
+
+// refreshThreshold is how close to its provider-side expiry a stored access
+// token can get before RequireAuth tries to refresh it.
+const refreshThreshold = 5 * time.Minute
+
+// sessionTTL is how long a client's session JWT/cookie stays valid. It is
+// independent of the much shorter provider access-token expiry: RequireAuth
+// keeps the underlying access token fresh via Refresher without forcing the
+// client to redo the oauth2 consent flow every time the access token would
+// have expired.
+const sessionTTL = 30 * 24 * time.Hour
+
+// claims are the JWT claims handed to the client. They never carry the real
+// provider access token, only a reference (Subject) to the server-side
+// sessionTokens entry that does.
+type claims struct {
+	Provider string `json:"provider"`
+	jwt.StandardClaims
+}
+
+// storedToken is a provider's full oauth2 credential kept server-side,
+// looked up by the subject of a validated session JWT. The refresh token
+// is kept here too, since that (not the access token) is what a Refresher
+// needs to mint a new access token. sessionExpiry mirrors the exp claim of
+// the JWT currently signed for this subject, so the janitor can evict an
+// entry once its JWT can no longer be presented.
+type storedToken struct {
+	provider      string
+	oauth         *OAuthToken
+	sessionExpiry time.Time
+}
+
+var sessionTokens = struct {
+	sync.Mutex
+	m map[string]storedToken
+}{m: make(map[string]storedToken)}
+
+func init() {
+	go sessionJanitor()
+}
+
+// sessionJanitor periodically evicts expired sessionTokens entries so a
+// server doesn't accumulate every session it has ever issued.
+func sessionJanitor() {
+	for range time.Tick(time.Hour) {
+		now := time.Now()
+
+		sessionTokens.Lock()
+		for id, st := range sessionTokens.m {
+			if now.After(st.sessionExpiry) {
+				delete(sessionTokens.m, id)
+			}
+		}
+		sessionTokens.Unlock()
+	}
+}
+
+// issueSession stores a freshly validated provider oauth2 credential
+// server-side under a random subject id and returns a signed JWT
+// referencing it, along with the JWT's own expiry.
+func issueSession(provider string, oauth *OAuthToken) (string, time.Time, error) {
+	sub := make([]byte, 16)
+	if _, err := rand.Read(sub); err != nil {
+		return "", time.Time{}, err
+	}
+	subject := base64.RawURLEncoding.EncodeToString(sub)
+
+	signed, expiry, err := signSession(provider, subject)
+	if err != nil {
+		return "", time.Time{}, err
+	}
+
+	sessionTokens.Lock()
+	sessionTokens.m[subject] = storedToken{provider: provider, oauth: oauth, sessionExpiry: expiry}
+	sessionTokens.Unlock()
+
+	return signed, expiry, nil
+}
+
+// signSession mints a session JWT for an existing subject, valid for
+// sessionTTL from now.
+func signSession(provider, subject string) (string, time.Time, error) {
+	now := time.Now()
+	expiry := now.Add(sessionTTL)
+
+	c := claims{
+		Provider: provider,
+		StandardClaims: jwt.StandardClaims{
+			Subject:   subject,
+			IssuedAt:  now.Unix(),
+			ExpiresAt: expiry.Unix(),
+		},
+	}
+
+	signed, err := jwt.NewWithClaims(jwt.SigningMethodHS256, c).SignedString(jwtSecret)
+	return signed, expiry, err
+}
+
+// RequireAuth parses and validates the session JWT, resolves the Provider
+// named in its claims, refreshes the underlying provider token if it's
+// close to expiry, and injects both into the request context so handlers
+// no longer need to repeat cookie/error boilerplate.
+func RequireAuth(next httprouter.Handle) httprouter.Handle {
+	return func(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
+		hint := providerHint(r, ps)
+
+		raw, err := bearerToken(r, hint)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusUnauthorized)
+			return
+		}
+
+		var c claims
+		_, err = jwt.ParseWithClaims(raw, &c, func(t *jwt.Token) (interface{}, error) {
+			if _, ok := t.Method.(*jwt.SigningMethodHMAC); !ok {
+				return nil, errors.New("unexpected signing method")
+			}
+			return jwtSecret, nil
+		})
+		if err != nil {
+			http.Error(w, "invalid session", http.StatusUnauthorized)
+			return
+		}
+
+		if hint != "" && hint != c.Provider {
+			http.Error(w, "invalid session", http.StatusUnauthorized)
+			return
+		}
+
+		p, ok := providerFor(c.Provider)
+		if !ok {
+			http.Error(w, "invalid session", http.StatusUnauthorized)
+			return
+		}
+
+		sessionTokens.Lock()
+		st, ok := sessionTokens.m[c.Subject]
+		sessionTokens.Unlock()
+		if !ok {
+			http.Error(w, "session expired", http.StatusUnauthorized)
+			return
+		}
+
+		if refresher, ok := p.(Refresher); ok && time.Until(st.oauth.Expiry) < refreshThreshold {
+			if oauth, err := refresher.Refresh(st.oauth.RefreshToken); err == nil {
+				st.oauth = oauth
+
+				if signed, expiry, err := signSession(c.Provider, c.Subject); err == nil {
+					st.sessionExpiry = expiry
+
+					http.SetCookie(w, &http.Cookie{
+						Name:    sessionCookieName(c.Provider),
+						Value:   signed,
+						Expires: expiry,
+						Path:    "/",
+					})
+				}
+
+				sessionTokens.Lock()
+				sessionTokens.m[c.Subject] = st
+				sessionTokens.Unlock()
+			}
+		}
+
+		next(w, r.WithContext(withSession(r.Context(), c.Provider, p, st.oauth.AccessToken, st.oauth.Expiry, c.Subject)), ps)
+	}
+}
+
+// providerHint returns the provider named by the request, if any, so
+// bearerToken knows which per-provider cookie to read when a session is
+// not carried in the Authorization header. A route's :provider URL segment
+// takes precedence over a ?provider= query parameter.
+func providerHint(r *http.Request, ps httprouter.Params) string {
+	if name := ps.ByName("provider"); name != "" {
+		return name
+	}
+
+	return r.FormValue("provider")
+}
+
+// bearerToken extracts the session JWT from the Authorization header
+// ("Bearer <token>"), falling back to the cookie for the provider named by
+// hint. With no Authorization header and no hint, the request can't be
+// matched to one of the (possibly several) provider sessions a client may
+// be holding, so authentication fails.
+func bearerToken(r *http.Request, hint string) (string, error) {
+	if h := r.Header.Get("Authorization"); strings.HasPrefix(h, "Bearer ") {
+		return strings.TrimPrefix(h, "Bearer "), nil
+	}
+
+	if hint == "" {
+		return "", errors.New("user not authenticated")
+	}
+
+	cookie, err := r.Cookie(sessionCookieName(hint))
+	if err != nil {
+		return "", errors.New("user not authenticated")
+	}
+
+	return cookie.Value, nil
+}
@@ -0,0 +1,57 @@
+package main
+
+import (
+	"context"
+	"time"
+)
+
+type contextKey int
+
+const (
+	providerContextKey contextKey = iota
+	providerNameContextKey
+	tokenContextKey
+	tokenExpiryContextKey
+	subjectContextKey
+)
+
+// withSession returns a context carrying the Provider, its registry name,
+// the access token and its expiry, and the session JWT's subject, as
+// resolved by RequireAuth.
+func withSession(ctx context.Context, name string, p Provider, token string, expiry time.Time, subject string) context.Context {
+	ctx = context.WithValue(ctx, providerContextKey, p)
+	ctx = context.WithValue(ctx, providerNameContextKey, name)
+	ctx = context.WithValue(ctx, tokenContextKey, token)
+	ctx = context.WithValue(ctx, tokenExpiryContextKey, expiry)
+	return context.WithValue(ctx, subjectContextKey, subject)
+}
+
+// sessionFromContext returns the Provider and access token injected by
+// RequireAuth. Handlers registered behind RequireAuth can rely on both
+// being present.
+func sessionFromContext(ctx context.Context) (Provider, string) {
+	p, _ := ctx.Value(providerContextKey).(Provider)
+	tok, _ := ctx.Value(tokenContextKey).(string)
+	return p, tok
+}
+
+// providerNameFromContext returns the registry name of the Provider
+// injected by RequireAuth (e.g. "google").
+func providerNameFromContext(ctx context.Context) string {
+	name, _ := ctx.Value(providerNameContextKey).(string)
+	return name
+}
+
+// tokenExpiryFromContext returns the provider access token's expiry, as
+// tracked server-side by RequireAuth.
+func tokenExpiryFromContext(ctx context.Context) time.Time {
+	expiry, _ := ctx.Value(tokenExpiryContextKey).(time.Time)
+	return expiry
+}
+
+// subjectFromContext returns the session JWT's subject injected by
+// RequireAuth, identifying which logged-in user owns the request.
+func subjectFromContext(ctx context.Context) string {
+	sub, _ := ctx.Value(subjectContextKey).(string)
+	return sub
+}
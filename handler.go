@@ -9,16 +9,12 @@ import (
 	"github.com/julienschmidt/httprouter"
 )
 
-// Add adds a new file to a the cloud storage provider listed in the cookie
+// Add adds a new file to the cloud storage provider resolved by RequireAuth.
+// Add must be registered behind RequireAuth.
 func Add(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
-	cookie, err := r.Cookie("token")
-	if err != nil {
-		http.Error(w, "User not authenticated", http.StatusUnauthorized)
-		return
-	}
+	p, tok := sessionFromContext(r.Context())
 
-	err = gd.Add(cookie.Value, r.Body, ps.ByName("filepath"))
-	if err != nil {
+	if err := p.Add(tok, r.Body, ps.ByName("filepath")); err != nil {
 		http.Error(w, err.Error(), http.StatusBadRequest)
 	}
 }
@@ -29,23 +25,28 @@ type AuthURLJSON struct {
 	URL string `json:"url"`
 }
 
-// AuthURL gets an oauth2 URL from one of the supported libraries (depending
-// on httprouter.Params) and returns the link encoded in JSON.
-// If httprouter.Params specify an unsupported library, http.StatusNotFound
+// AuthURL gets an oauth2 URL from one of the registered providers (depending
+// on httprouter.Params) and returns the link encoded in JSON. A random,
+// server-side-tracked state token is generated and embedded in the URL so
+// Validate can later confirm the callback belongs to this flow.
+// If httprouter.Params specify an unsupported provider, http.StatusNotFound
 // is returned.
 func AuthURL(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
-	var a = AuthURLJSON{}
+	name := ps.ByName("provider")
 
-	switch ps.ByName("provider") {
-	default:
+	p, ok := providerFor(name)
+	if !ok {
 		http.Error(w, "Provider not found", http.StatusNotFound)
 		return
+	}
 
-	case "google":
-		a = AuthURLJSON{URL: gd.AuthURL()}
+	state, err := newState(name)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
 	}
 
-	j, _ := json.Marshal(a)
+	j, _ := json.Marshal(AuthURLJSON{URL: p.AuthURL(state)})
 
 	w.Header().Set("Content-Type", "application/json")
 	w.Write([]byte(j))
@@ -57,17 +58,15 @@ type BrowseJSON struct {
 	FileList []string `json:"file_list"`
 }
 
-// Browse returns the content of a directory as a json list
+// Browse returns the content of a directory as a json list. Browse must be
+// registered behind RequireAuth.
 func Browse(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
-	cookie, err := r.Cookie("token")
-	if err != nil {
-		http.Error(w, "authenticated", http.StatusUnauthorized)
-		return
-	}
+	p, tok := sessionFromContext(r.Context())
 
-	list, err := gd.Browse(cookie.Value, ps.ByName("filepath"))
+	list, err := p.Browse(tok, ps.ByName("filepath"))
 	if err != nil {
 		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
 	}
 
 	j, _ := json.Marshal(BrowseJSON{FileList: list})
@@ -76,54 +75,22 @@ func Browse(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
 	w.Write([]byte(j))
 }
 
-// Delete deletes the given file
+// Delete deletes the given file. Delete must be registered behind
+// RequireAuth.
 func Delete(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
-	cookie, err := r.Cookie("token")
-	if err != nil {
-		http.Error(w, "User not authenticated", http.StatusUnauthorized)
-		return
-	}
+	p, tok := sessionFromContext(r.Context())
 
-	err = gd.Delete(cookie.Value, ps.ByName("filepath"))
-	if err != nil {
+	if err := p.Delete(tok, ps.ByName("filepath")); err != nil {
 		http.Error(w, err.Error(), http.StatusBadRequest)
 	}
 }
 
-// PublishJSON is the struct which will be encoded into JSON once it's been
-// initialized by Publish
-type PublishJSON struct {
-	URL string `json:"url"`
-}
-
-// Publish sets the given file to public
-func Publish(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
-	cookie, err := r.Cookie("token")
-	if err != nil {
-		http.Error(w, "User not authenticated", http.StatusUnauthorized)
-		return
-	}
-
-	link, err := gd.Publish(cookie.Value, ps.ByName("filepath"))
-	if err != nil {
-		http.Error(w, err.Error(), http.StatusBadRequest)
-	}
-
-	j, _ := json.Marshal(PublishJSON{URL: link})
-
-	w.Header().Set("Content-Type", "application/json")
-	w.Write([]byte(j))
-}
-
-// Read gets the given file and returns its content
+// Read gets the given file and returns its content. Read must be registered
+// behind RequireAuth.
 func Read(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
-	cookie, err := r.Cookie("token")
-	if err != nil {
-		http.Error(w, "User not authenticated", http.StatusUnauthorized)
-		return
-	}
+	p, tok := sessionFromContext(r.Context())
 
-	response, err := gd.Read(cookie.Value, ps.ByName("filepath"))
+	response, err := p.Read(tok, ps.ByName("filepath"))
 	if err != nil {
 		http.Error(w, err.Error(), http.StatusBadRequest)
 		return
@@ -140,35 +107,49 @@ func Read(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
 // ValidateJSON is the struct which will be encoded into JSON once it's been
 // initialized by Validate().
 type ValidateJSON struct {
-	Token  string    `json:"access_token"`
-	Expiry time.Time `json:"expiry,omitempty"`
+	SessionToken string    `json:"session_token"`
+	Expiry       time.Time `json:"expiry,omitempty"`
 }
 
 // Validate reads the Form Values of a request and validates the oauth2.
-// After the code is validated, it returns the user token.
+// The state token is checked against the server-side store populated by
+// AuthURL to determine the provider and reject reused, expired or unknown
+// states. Once the code is validated, the real provider access token is
+// kept server-side and a signed session JWT referencing it is set as a
+// cookie and returned to the client.
 func Validate(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
-	state := r.FormValue("state")
-
-	var err error
-	var token string
-	var expiry time.Time
-
-	// TODO: validate state token and map to a specific provider
-	switch state {
-	default:
+	name, err := consumeState(r.FormValue("state"))
+	if err != nil {
 		http.Error(w, "Invalid state token", http.StatusBadRequest)
 		return
+	}
 
-	case "google":
-		token, expiry, err = gd.Validate(r.FormValue("code"))
+	p, ok := providerFor(name)
+	if !ok {
+		http.Error(w, "Invalid state token", http.StatusBadRequest)
+		return
 	}
 
+	oauth, err := p.Validate(r.FormValue("code"))
 	if err != nil {
 		http.Error(w, "Auth Code invalid", http.StatusBadRequest)
 		return
 	}
 
-	j, _ := json.Marshal(ValidateJSON{Token: token, Expiry: expiry})
+	session, expiry, err := issueSession(name, oauth)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	http.SetCookie(w, &http.Cookie{
+		Name:    sessionCookieName(name),
+		Value:   session,
+		Expires: expiry,
+		Path:    "/",
+	})
+
+	j, _ := json.Marshal(ValidateJSON{SessionToken: session, Expiry: expiry})
 
 	w.Header().Set("Content-Type", "application/json")
 	w.Write([]byte(j))
@@ -0,0 +1,52 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"time"
+
+	"github.com/julienschmidt/httprouter"
+)
+
+// MeJSON is the struct which will be encoded into JSON once it's been
+// initialized by Me().
+type MeJSON struct {
+	Email       string    `json:"email"`
+	Name        string    `json:"name"`
+	Picture     string    `json:"picture"`
+	Provider    string    `json:"provider"`
+	Quota       Quota     `json:"quota"`
+	TokenExpiry time.Time `json:"token_expiry"`
+}
+
+// Me returns the authenticated user's identity and storage quota for the
+// provider behind their session, so the frontend can render an account
+// panel without touching the provider's APIs directly. Me must be
+// registered behind RequireAuth.
+func Me(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
+	p, tok := sessionFromContext(r.Context())
+
+	info, err := p.UserInfo(tok)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadGateway)
+		return
+	}
+
+	quota, err := p.Quota(tok)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadGateway)
+		return
+	}
+
+	j, _ := json.Marshal(MeJSON{
+		Email:       info.Email,
+		Name:        info.Name,
+		Picture:     info.Picture,
+		Provider:    providerNameFromContext(r.Context()),
+		Quota:       *quota,
+		TokenExpiry: tokenExpiryFromContext(r.Context()),
+	})
+
+	w.Header().Set("Content-Type", "application/json")
+	w.Write([]byte(j))
+}
@@ -0,0 +1,89 @@
+package main
+
+import (
+	"io"
+	"net/http"
+	"time"
+)
+
+// Provider is implemented by each supported cloud storage backend. Handlers
+// operate against this interface instead of a specific provider package so
+// that new backends can be registered without editing every handler.
+type Provider interface {
+	// AuthURL returns the oauth2 URL a user should be redirected to in
+	// order to authenticate with the provider, with state embedded as the
+	// oauth2 "state" parameter.
+	AuthURL(state string) string
+
+	// Validate exchanges an oauth2 code for a full oauth2 credential.
+	Validate(code string) (*OAuthToken, error)
+
+	Add(token string, r io.Reader, filepath string) error
+	Browse(token string, filepath string) ([]string, error)
+	Delete(token string, filepath string) error
+	Read(token string, filepath string) (*http.Response, error)
+
+	// Publish shares a file publicly per opts and returns its share URL.
+	Publish(token, filepath string, opts PublishOptions) (string, error)
+
+	// Unpublish revokes a file's public share.
+	Unpublish(token, filepath string) error
+
+	// ListPublished returns every file the user currently has published.
+	ListPublished(token string) ([]PublishedFile, error)
+
+	// UserInfo returns the identity of the user the token belongs to.
+	UserInfo(token string) (*UserInfo, error)
+
+	// Quota returns the user's storage usage for this provider.
+	Quota(token string) (*Quota, error)
+}
+
+// UserInfo is a provider-agnostic view of the authenticated user's
+// identity, as returned by a provider's userinfo endpoint.
+type UserInfo struct {
+	Email   string `json:"email"`
+	Name    string `json:"name"`
+	Picture string `json:"picture"`
+}
+
+// Quota is a provider-agnostic view of the authenticated user's storage
+// usage, in bytes.
+type Quota struct {
+	Limit   int64 `json:"limit"`
+	Used    int64 `json:"used"`
+	Trashed int64 `json:"trashed"`
+}
+
+// OAuthToken is the full oauth2 credential for a provider session. The
+// refresh token is kept alongside the access token so Refresher.Refresh
+// can mint a new access token without taking the user back through the
+// consent flow.
+type OAuthToken struct {
+	AccessToken  string
+	RefreshToken string
+	Expiry       time.Time
+}
+
+// providers holds every registered Provider, keyed by the name used in the
+// "provider" URL segment and carried in session JWT claims (see auth.go).
+//
+// Additional backends (Dropbox, OneDrive, S3, git-hosted storage such as
+// Bitbucket or GitHub) register themselves here the same way googleProvider
+// does, so no handler needs to change when a new one is added.
+var providers = map[string]Provider{
+	"google": googleProvider{},
+}
+
+// providerFor looks up a registered Provider by name.
+func providerFor(name string) (Provider, bool) {
+	p, ok := providers[name]
+	return p, ok
+}
+
+// Refresher is implemented by providers whose oauth2 access token can be
+// refreshed without taking the user back through the consent flow.
+// RequireAuth uses it to keep long-lived sessions alive.
+type Refresher interface {
+	Refresh(refreshToken string) (*OAuthToken, error)
+}
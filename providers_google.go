@@ -0,0 +1,105 @@
+package main
+
+import (
+	"io"
+	"net/http"
+
+	"github.com/pierrebeaucamp/warehouse-manager/gd"
+)
+
+// googleProvider adapts the existing gd package to the Provider interface.
+//
+// This adapter pins the gd surface the rest of the series assumes: AuthURL
+// taking a state argument, Validate/Refresh returning a credential with both
+// AccessToken and RefreshToken, and Unpublish/ListPublished/UserInfo/Quota/
+// PublishOptions existing at all. None of that lives in this repo, so a gd
+// change matching this contract has to land alongside these commits (or be
+// vendored) before the series builds.
+type googleProvider struct{}
+
+func (googleProvider) AuthURL(state string) string {
+	return gd.AuthURL(state)
+}
+
+func (googleProvider) Validate(code string) (*OAuthToken, error) {
+	tok, err := gd.Validate(code)
+	if err != nil {
+		return nil, err
+	}
+
+	return &OAuthToken{AccessToken: tok.AccessToken, RefreshToken: tok.RefreshToken, Expiry: tok.Expiry}, nil
+}
+
+func (googleProvider) Refresh(refreshToken string) (*OAuthToken, error) {
+	tok, err := gd.Refresh(refreshToken)
+	if err != nil {
+		return nil, err
+	}
+
+	return &OAuthToken{AccessToken: tok.AccessToken, RefreshToken: tok.RefreshToken, Expiry: tok.Expiry}, nil
+}
+
+func (googleProvider) Add(token string, r io.Reader, filepath string) error {
+	return gd.Add(token, r, filepath)
+}
+
+func (googleProvider) Browse(token string, filepath string) ([]string, error) {
+	return gd.Browse(token, filepath)
+}
+
+func (googleProvider) Delete(token string, filepath string) error {
+	return gd.Delete(token, filepath)
+}
+
+func (googleProvider) Publish(token, filepath string, opts PublishOptions) (string, error) {
+	return gd.Publish(token, filepath, gd.PublishOptions{
+		ExpiresAt:     opts.ExpiresAt,
+		Role:          opts.Role,
+		AllowedEmails: opts.AllowedEmails,
+	})
+}
+
+func (googleProvider) Unpublish(token, filepath string) error {
+	return gd.Unpublish(token, filepath)
+}
+
+func (googleProvider) ListPublished(token string) ([]PublishedFile, error) {
+	published, err := gd.ListPublished(token)
+	if err != nil {
+		return nil, err
+	}
+
+	files := make([]PublishedFile, len(published))
+	for i, f := range published {
+		files[i] = PublishedFile{
+			Filepath:  f.Filepath,
+			URL:       f.URL,
+			Role:      f.Role,
+			ExpiresAt: f.ExpiresAt,
+		}
+	}
+
+	return files, nil
+}
+
+func (googleProvider) Read(token string, filepath string) (*http.Response, error) {
+	return gd.Read(token, filepath)
+}
+
+func (googleProvider) UserInfo(token string) (*UserInfo, error) {
+	info, err := gd.UserInfo(token)
+	if err != nil {
+		return nil, err
+	}
+
+	return &UserInfo{Email: info.Email, Name: info.Name, Picture: info.Picture}, nil
+}
+
+func (googleProvider) Quota(token string) (*Quota, error) {
+	quota, err := gd.Quota(token)
+	if err != nil {
+		return nil, err
+	}
+
+	return &Quota{Limit: quota.Limit, Used: quota.Used, Trashed: quota.Trashed}, nil
+}
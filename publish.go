@@ -0,0 +1,96 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"time"
+
+	"github.com/julienschmidt/httprouter"
+)
+
+// PublishOptions controls how a file is shared when published: how long
+// the share lasts, what the grantee can do with it, and who it's
+// restricted to. This maps directly onto Drive's permission model
+// (type: anyone|user, expirationTime, role: reader|commenter|writer).
+type PublishOptions struct {
+	ExpiresAt     time.Time `json:"expiresAt,omitempty"`
+	Role          string    `json:"role,omitempty"`
+	AllowedEmails []string  `json:"allowedEmails,omitempty"`
+}
+
+// PublishedFile describes one file a user currently has published.
+type PublishedFile struct {
+	Filepath  string    `json:"filepath"`
+	URL       string    `json:"url"`
+	Role      string    `json:"role"`
+	ExpiresAt time.Time `json:"expiresAt,omitempty"`
+}
+
+// PublishJSON is the struct which will be encoded into JSON once it's been
+// initialized by Publish.
+type PublishJSON struct {
+	URL string `json:"url"`
+}
+
+// Publish sets the given file to public, optionally time-limited and
+// restricted to a role or a set of email addresses per the JSON request
+// body. Publish must be registered behind RequireAuth.
+func Publish(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
+	p, tok := sessionFromContext(r.Context())
+
+	var opts PublishOptions
+	if r.ContentLength != 0 {
+		if err := json.NewDecoder(r.Body).Decode(&opts); err != nil {
+			http.Error(w, "invalid request body", http.StatusBadRequest)
+			return
+		}
+	}
+
+	if opts.Role == "" {
+		opts.Role = "reader"
+	}
+
+	link, err := p.Publish(tok, ps.ByName("filepath"), opts)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	j, _ := json.Marshal(PublishJSON{URL: link})
+
+	w.Header().Set("Content-Type", "application/json")
+	w.Write([]byte(j))
+}
+
+// PublishRevoke revokes a file's public share. PublishRevoke must be
+// registered behind RequireAuth.
+func PublishRevoke(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
+	p, tok := sessionFromContext(r.Context())
+
+	if err := p.Unpublish(tok, ps.ByName("filepath")); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+	}
+}
+
+// PublishListJSON is the struct which will be encoded into JSON once it's
+// been initialized by PublishList.
+type PublishListJSON struct {
+	Files []PublishedFile `json:"files"`
+}
+
+// PublishList returns every file the user currently has published.
+// PublishList must be registered behind RequireAuth.
+func PublishList(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
+	p, tok := sessionFromContext(r.Context())
+
+	files, err := p.ListPublished(tok)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	j, _ := json.Marshal(PublishListJSON{Files: files})
+
+	w.Header().Set("Content-Type", "application/json")
+	w.Write([]byte(j))
+}
@@ -0,0 +1,35 @@
+package main
+
+import (
+	"github.com/julienschmidt/httprouter"
+)
+
+// NewRouter builds the httprouter.Router for the service, registering every
+// handler under its route and wrapping the ones that require a session
+// behind RequireAuth. This is the one place that has to agree with every
+// handler's own "must be registered behind RequireAuth" doc comment; keep
+// it in sync when adding a handler.
+func NewRouter() *httprouter.Router {
+	r := httprouter.New()
+
+	r.GET("/auth/:provider", AuthURL)
+	r.GET("/auth/:provider/callback", Validate)
+
+	r.PUT("/files/:provider/*filepath", RequireAuth(Add))
+	r.GET("/files/:provider/*filepath", RequireAuth(Read))
+	r.DELETE("/files/:provider/*filepath", RequireAuth(Delete))
+	r.GET("/browse/:provider/*filepath", RequireAuth(Browse))
+
+	r.POST("/publish/:provider/*filepath", RequireAuth(Publish))
+	r.DELETE("/publish/:provider/*filepath", RequireAuth(PublishRevoke))
+	r.GET("/publish/:provider", RequireAuth(PublishList))
+
+	r.POST("/upload/:provider", RequireAuth(UploadSessionCreate))
+	r.PUT("/upload/:provider/:session", RequireAuth(UploadChunk))
+	r.POST("/upload/:provider/:session/commit", RequireAuth(UploadCommit))
+	r.GET("/upload/:provider/:session", RequireAuth(UploadStatus))
+
+	r.GET("/me/:provider", RequireAuth(Me))
+
+	return r
+}
@@ -0,0 +1,87 @@
+package main
+
+import (
+	"crypto/rand"
+	"encoding/base64"
+	"errors"
+	"sync"
+	"time"
+)
+
+// stateTTL is how long an oauth2 state token stays valid for. AuthURL
+// callers are expected to complete the provider's consent flow and hit
+// Validate well within this window.
+const stateTTL = 10 * time.Minute
+
+// oauthState is what a generated state token resolves to.
+type oauthState struct {
+	provider  string
+	createdAt time.Time
+}
+
+// stateStore is a short-TTL, in-memory state -> oauthState map used to
+// protect the oauth2 flow against CSRF: AuthURL mints a random state and
+// records which provider it belongs to, Validate consumes it and rejects
+// anything unknown, reused or expired.
+var stateStore = struct {
+	sync.Mutex
+	m map[string]oauthState
+}{m: make(map[string]oauthState)}
+
+func init() {
+	go stateJanitor()
+}
+
+// stateJanitor periodically evicts state tokens that were minted by
+// AuthURL but never consumed (an abandoned consent flow), so the store
+// doesn't grow unbounded.
+func stateJanitor() {
+	for range time.Tick(stateTTL) {
+		now := time.Now()
+
+		stateStore.Lock()
+		for state, s := range stateStore.m {
+			if now.Sub(s.createdAt) > stateTTL {
+				delete(stateStore.m, state)
+			}
+		}
+		stateStore.Unlock()
+	}
+}
+
+// newState generates a cryptographically random, base64url-encoded state
+// token for the given provider and records it server-side.
+func newState(provider string) (string, error) {
+	b := make([]byte, 32)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+
+	state := base64.RawURLEncoding.EncodeToString(b)
+
+	stateStore.Lock()
+	stateStore.m[state] = oauthState{provider: provider, createdAt: time.Now()}
+	stateStore.Unlock()
+
+	return state, nil
+}
+
+// consumeState validates and removes a state token, returning the provider
+// it was minted for. A state can only be consumed once.
+func consumeState(state string) (string, error) {
+	stateStore.Lock()
+	defer stateStore.Unlock()
+
+	s, ok := stateStore.m[state]
+	if !ok {
+		return "", errors.New("unknown state token")
+	}
+
+	delete(stateStore.m, state)
+
+	if time.Since(s.createdAt) > stateTTL {
+		return "", errors.New("expired state token")
+	}
+
+	return s.provider, nil
+}
@@ -0,0 +1,361 @@
+package main
+
+import (
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/julienschmidt/httprouter"
+)
+
+// uploadChunkSize is the chunk size advertised to clients for a resumable
+// upload.
+const uploadChunkSize = 8 << 20 // 8MiB
+
+// uploadSessionTTL is how long an upload session may sit idle before the
+// janitor reclaims its spool file.
+const uploadSessionTTL = 24 * time.Hour
+
+// uploadSpoolDir is where partially-received uploads are buffered until
+// they're committed to a Provider.
+var uploadSpoolDir = os.TempDir()
+
+// byteRange is a half-open [start, end) range of bytes received for an
+// upload session.
+type byteRange struct {
+	Start int64 `json:"start"`
+	End   int64 `json:"end"`
+}
+
+// uploadSession tracks a single resumable upload in progress.
+type uploadSession struct {
+	sync.Mutex
+
+	ID       string      `json:"id"`
+	Owner    string      `json:"-"`
+	Provider string      `json:"provider"`
+	Filepath string      `json:"filepath"`
+	Size     int64       `json:"size"`
+	Received []byteRange `json:"received"`
+	Expiry   time.Time   `json:"expiry"`
+}
+
+// spoolPath returns where this session's partially-received content is
+// buffered on disk.
+func (s *uploadSession) spoolPath() string {
+	return uploadSpoolDir + "/upload-" + s.ID
+}
+
+// complete reports whether every byte up to Size has been received.
+func (s *uploadSession) complete() bool {
+	if s.Size == 0 {
+		return len(s.Received) > 0
+	}
+
+	ranges := append([]byteRange(nil), s.Received...)
+	sort.Slice(ranges, func(i, j int) bool { return ranges[i].Start < ranges[j].Start })
+
+	var next int64
+	for _, rg := range ranges {
+		if rg.Start > next {
+			return false
+		}
+		if rg.End > next {
+			next = rg.End
+		}
+	}
+
+	return next >= s.Size
+}
+
+var uploadSessions = struct {
+	sync.Mutex
+	m map[string]*uploadSession
+}{m: make(map[string]*uploadSession)}
+
+func init() {
+	go uploadJanitor()
+}
+
+// uploadJanitor periodically removes expired upload sessions and their
+// spool files so an abandoned upload doesn't leak disk space forever.
+func uploadJanitor() {
+	for range time.Tick(time.Hour) {
+		now := time.Now()
+
+		uploadSessions.Lock()
+		for id, s := range uploadSessions.m {
+			if now.After(s.Expiry) {
+				os.Remove(s.spoolPath())
+				delete(uploadSessions.m, id)
+			}
+		}
+		uploadSessions.Unlock()
+	}
+}
+
+// newUploadID returns a random, URL-safe upload session identifier.
+func newUploadID() (string, error) {
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+
+	return base64.RawURLEncoding.EncodeToString(b), nil
+}
+
+// UploadSessionRequest is the JSON body expected by UploadSessionCreate.
+type UploadSessionRequest struct {
+	Filepath string `json:"filepath"`
+	Size     int64  `json:"size"`
+}
+
+// UploadSessionJSON is returned by UploadSessionCreate.
+type UploadSessionJSON struct {
+	SessionID string `json:"session_id"`
+	ChunkSize int64  `json:"chunk_size"`
+}
+
+// UploadSessionCreate starts a resumable upload, returning a session ID and
+// the chunk size the client should use for subsequent PUTs. UploadSessionCreate
+// must be registered behind RequireAuth.
+func UploadSessionCreate(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
+	var req UploadSessionRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "invalid request body", http.StatusBadRequest)
+		return
+	}
+
+	if req.Filepath == "" {
+		http.Error(w, "filepath is required", http.StatusBadRequest)
+		return
+	}
+
+	id, err := newUploadID()
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	s := &uploadSession{
+		ID:       id,
+		Owner:    subjectFromContext(r.Context()),
+		Provider: providerNameFromContext(r.Context()),
+		Filepath: req.Filepath,
+		Size:     req.Size,
+		Expiry:   time.Now().Add(uploadSessionTTL),
+	}
+
+	f, err := os.Create(s.spoolPath())
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	f.Close()
+
+	uploadSessions.Lock()
+	uploadSessions.m[id] = s
+	uploadSessions.Unlock()
+
+	j, _ := json.Marshal(UploadSessionJSON{SessionID: id, ChunkSize: uploadChunkSize})
+
+	w.Header().Set("Content-Type", "application/json")
+	w.Write([]byte(j))
+}
+
+// UploadChunk accepts a byte range of an in-progress upload, addressed by
+// Content-Range, and stores it in the session's spool file. UploadChunk
+// must be registered behind RequireAuth.
+func UploadChunk(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
+	s, err := lookupUploadSession(ps.ByName("session"), subjectFromContext(r.Context()))
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusNotFound)
+		return
+	}
+
+	start, end, total, err := parseContentRange(r.Header.Get("Content-Range"))
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	s.Lock()
+	defer s.Unlock()
+
+	if total > 0 {
+		s.Size = total
+	}
+
+	f, err := os.OpenFile(s.spoolPath(), os.O_WRONLY, 0o600)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	defer f.Close()
+
+	if _, err := f.Seek(start, 0); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	if _, err := io.CopyN(f, r.Body, end-start); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	s.Received = append(s.Received, byteRange{Start: start, End: end})
+	s.Expiry = time.Now().Add(uploadSessionTTL)
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// UploadCommitJSON is returned by UploadCommit.
+type UploadCommitJSON struct {
+	Filepath string `json:"filepath"`
+}
+
+// UploadCommit streams the assembled upload to the provider once every byte
+// has been received, then discards the session and its spool file. The
+// access token used is the one RequireAuth resolved for this request
+// (refreshed first if it was close to expiry), not whatever was live when
+// the upload session was created — a resumed
+// upload can easily outlive a single provider access token.
+// UploadCommit must be registered behind RequireAuth.
+func UploadCommit(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
+	_, tok := sessionFromContext(r.Context())
+
+	s, err := lookupUploadSession(ps.ByName("session"), subjectFromContext(r.Context()))
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusNotFound)
+		return
+	}
+
+	s.Lock()
+	if !s.complete() {
+		s.Unlock()
+		http.Error(w, "upload is not complete", http.StatusConflict)
+		return
+	}
+	s.Unlock()
+
+	p, ok := providerFor(s.Provider)
+	if !ok {
+		http.Error(w, "provider not found", http.StatusBadRequest)
+		return
+	}
+
+	f, err := os.Open(s.spoolPath())
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	defer f.Close()
+
+	if err := p.Add(tok, f, s.Filepath); err != nil {
+		http.Error(w, err.Error(), http.StatusBadGateway)
+		return
+	}
+
+	uploadSessions.Lock()
+	delete(uploadSessions.m, s.ID)
+	uploadSessions.Unlock()
+	os.Remove(s.spoolPath())
+
+	j, _ := json.Marshal(UploadCommitJSON{Filepath: s.Filepath})
+
+	w.Header().Set("Content-Type", "application/json")
+	w.Write([]byte(j))
+}
+
+// UploadStatus reports how much of an in-progress upload has been received,
+// so a client can resume after a failure. UploadStatus must be registered
+// behind RequireAuth.
+func UploadStatus(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
+	s, err := lookupUploadSession(ps.ByName("session"), subjectFromContext(r.Context()))
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusNotFound)
+		return
+	}
+
+	s.Lock()
+	defer s.Unlock()
+
+	j, _ := json.Marshal(s)
+
+	w.Header().Set("Content-Type", "application/json")
+	w.Write([]byte(j))
+}
+
+// lookupUploadSession fetches an upload session by ID, returning an error
+// if it doesn't exist, has expired, or doesn't belong to owner (the
+// session JWT subject of the caller). A session belonging to someone else
+// is reported identically to one that doesn't exist, so a guessed ID can't
+// be used to probe for other users' in-progress uploads.
+func lookupUploadSession(id, owner string) (*uploadSession, error) {
+	uploadSessions.Lock()
+	s, ok := uploadSessions.m[id]
+	uploadSessions.Unlock()
+
+	if !ok || s.Owner != owner {
+		return nil, errors.New("unknown upload session")
+	}
+
+	if time.Now().After(s.Expiry) {
+		return nil, errors.New("upload session expired")
+	}
+
+	return s, nil
+}
+
+// parseContentRange parses a "bytes start-end/total" Content-Range header
+// as sent by a resumable upload client.
+func parseContentRange(header string) (start, end, total int64, err error) {
+	if header == "" {
+		return 0, 0, 0, errors.New("Content-Range header is required")
+	}
+
+	header = strings.TrimPrefix(header, "bytes ")
+
+	dash := strings.IndexByte(header, '-')
+	slash := strings.IndexByte(header, '/')
+	if dash < 0 || slash < dash {
+		return 0, 0, 0, fmt.Errorf("malformed Content-Range: %q", header)
+	}
+
+	start, err = strconv.ParseInt(header[:dash], 10, 64)
+	if err != nil {
+		return 0, 0, 0, fmt.Errorf("malformed Content-Range: %q", header)
+	}
+
+	end, err = strconv.ParseInt(header[dash+1:slash], 10, 64)
+	if err != nil {
+		return 0, 0, 0, fmt.Errorf("malformed Content-Range: %q", header)
+	}
+	end++ // Content-Range end is inclusive; our ranges are half-open.
+
+	if totalStr := header[slash+1:]; totalStr != "*" {
+		total, err = strconv.ParseInt(totalStr, 10, 64)
+		if err != nil {
+			return 0, 0, 0, fmt.Errorf("malformed Content-Range: %q", header)
+		}
+	}
+
+	if start < 0 || end < start {
+		return 0, 0, 0, fmt.Errorf("malformed Content-Range: %q", header)
+	}
+	if total > 0 && end > total {
+		return 0, 0, 0, fmt.Errorf("malformed Content-Range: %q", header)
+	}
+
+	return start, end, total, nil
+}